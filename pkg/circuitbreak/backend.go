@@ -0,0 +1,113 @@
+/*
+ * Copyright 2023 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package circuitbreak
+
+import (
+	"github.com/bytedance/gopkg/cloud/circuitbreaker"
+)
+
+// State represents the tripping state of a single breaker key.
+type State int
+
+// Constants for State.
+const (
+	// Closed means requests for the key are allowed through.
+	Closed State = iota
+	// Open means requests for the key are rejected.
+	Open
+	// HalfOpen means a limited number of requests are allowed through to probe
+	// whether the key has recovered.
+	HalfOpen
+)
+
+// Generation is an opaque token a Backend hands out from IsAllowed and receives
+// back in Succeed/Fail/Timeout. An implementation may use it to identify a
+// breaker's run between two consecutive trips, so a call for a stale generation
+// can be safely ignored, or to identify a single admitted call (as
+// gobreakeradapter does); panelBackend always uses 0.
+type Generation uint64
+
+// Backend abstracts the underlying circuit breaker bookkeeping so NewCircuitBreakerMW
+// is not tied to any particular implementation. This lets users swap in something
+// like sony/gobreaker or afex/hystrix-go instead of the default bytedance/gopkg Panel.
+// See the circuitbreak/gobreakeradapter and circuitbreak/hystrixadapter subpackages
+// for ready-made adapters.
+type Backend interface {
+	// IsAllowed reports whether a request for key may proceed, and the generation
+	// it was allowed under. The generation must be passed back to Succeed / Fail /
+	// Timeout so implementations that reset counters on transition (e.g. half-open
+	// probes) can discard results from a superseded generation.
+	IsAllowed(key string) (allowed bool, generation Generation)
+
+	// Succeed records a successful call for key made under generation.
+	Succeed(key string, generation Generation)
+
+	// Fail records a failed call for key made under generation.
+	Fail(key string, generation Generation)
+
+	// Timeout records a timed-out call for key made under generation.
+	Timeout(key string, generation Generation)
+
+	// State returns the current state of key, e.g. for an admin endpoint to dump.
+	State(key string) State
+
+	// Subscribe registers fn to be called whenever any key transitions state.
+	// Implementations that cannot support this may make it a no-op.
+	Subscribe(fn func(key string, from, to State))
+}
+
+// panelBackend adapts a circuitbreaker.Panel, the bytedance/gopkg implementation
+// this package has always used, to the Backend interface. It is the default
+// Backend so existing NewCircuitBreakerMW callers see no behavior change.
+type panelBackend struct {
+	panel circuitbreaker.Panel
+}
+
+// NewPanelBackend wraps panel as a Backend.
+func NewPanelBackend(panel circuitbreaker.Panel) Backend {
+	return &panelBackend{panel: panel}
+}
+
+func (b *panelBackend) IsAllowed(key string) (bool, Generation) {
+	return b.panel.IsAllowed(key), 0
+}
+
+func (b *panelBackend) Succeed(key string, _ Generation) {
+	b.panel.Succeed(key)
+}
+
+func (b *panelBackend) Fail(key string, _ Generation) {
+	b.panel.Fail(key)
+}
+
+func (b *panelBackend) Timeout(key string, _ Generation) {
+	b.panel.Timeout(key)
+}
+
+func (b *panelBackend) State(key string) State {
+	breaker, ok := b.panel.DumpBreakers()[key]
+	if !ok || breaker.State() == circuitbreaker.Closed {
+		return Closed
+	}
+	if breaker.State() == circuitbreaker.HalfOpen {
+		return HalfOpen
+	}
+	return Open
+}
+
+// Subscribe is a no-op: circuitbreaker.Panel has no transition notification hook.
+func (b *panelBackend) Subscribe(func(key string, from, to State)) {}
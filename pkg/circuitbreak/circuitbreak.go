@@ -78,6 +78,123 @@ type Control struct {
 	// Implement this to provide more detailed information about the circuit breaker.
 	// The err argument is always a kerrors.ErrCircuitBreak.
 	DecorateError func(ctx context.Context, request interface{}, err error) error
+
+	// TripCondition, if set, trips a key open based on a rolling window of latency,
+	// error type, and classified response status, in addition to the ErrorRate-based
+	// tripping the Panel itself performs. See LatencyAtQuantile, NetworkErrorRatio,
+	// ResponseCodeRatio, And and Or.
+	TripCondition TripCondition
+
+	// ClassifyResponse, used together with TripCondition, maps a response to a status
+	// code for ResponseCodeRatio-style conditions. Return ok=false to exclude a
+	// response from the classified sample.
+	ClassifyResponse func(response interface{}) (statusCode int, ok bool)
+
+	// StateMachine, if set, replaces the middleware's default implicit recovery
+	// logic (the sharedTicker clearing a single per-middleware "has open" flag) with
+	// an explicit per-key Closed / Open / HalfOpen machine, including half-open
+	// probing and a configurable reset backoff. See StateMachine.
+	StateMachine *StateMachine
+
+	// StateChangeHook is invoked on every state transition of StateMachine, if set.
+	StateChangeHook StateChangeHook
+
+	// InvocationTimeout, if it returns > 0, bounds a single call to next with a
+	// context.WithTimeout; a call that exceeds it is counted as TypeTimeout for the
+	// breaker regardless of what GetErrorType would otherwise classify it as.
+	InvocationTimeout func(ctx context.Context, request interface{}) time.Duration
+
+	// Fallback, if set, is invoked in place of surfacing kerrors.ErrCircuitBreak when
+	// the breaker is open, and in place of the deadline error when InvocationTimeout
+	// fires. It should populate response and return the error to propagate to the
+	// caller. When Fallback is nil, behavior is unchanged: DecorateError still wraps
+	// the circuit breaker error.
+	Fallback func(ctx context.Context, request, response interface{}, tripErr error) error
+
+	// CancelInflightOnOpen opts into tracking every in-flight request under its
+	// breaker key in a registry, so that the instant the key trips open (observed
+	// via StateChangeHook), every request already in flight for that key is
+	// cancelled via its context rather than left to run until its own timeout.
+	// Requires StateMachine to be set, since that is what drives state transitions.
+	CancelInflightOnOpen bool
+
+	// FallbackTarget, FallbackRegistry and FallbackInvoke together let a tripped
+	// request be routed to a locally registered degraded-mode implementation
+	// instead of returning kerrors.ErrCircuitBreak. FallbackTarget nominates the
+	// service/method to dispatch to; FallbackRegistry resolves it using the same
+	// svcSearchMap semantics server.services uses for fallbackSvc. When set, this
+	// takes priority over Fallback.
+	FallbackTarget   func(ctx context.Context, request interface{}) (svcName, methodName string, ok bool)
+	FallbackRegistry *FallbackRegistry
+	FallbackInvoke   FallbackInvoke
+}
+
+// invoke runs next, applying InvocationTimeout if configured. timedOut reports
+// whether the call was cut short by that timeout, as opposed to by next itself
+// returning context.DeadlineExceeded on its own.
+func (c *Control) invoke(ctx context.Context, next endpoint.Endpoint, request, response interface{}) (err error, timedOut bool) {
+	if c.InvocationTimeout == nil {
+		return next(ctx, request, response), false
+	}
+	d := c.InvocationTimeout(ctx, request)
+	if d <= 0 {
+		return next(ctx, request, response), false
+	}
+	tctx, cancel := context.WithTimeout(ctx, d)
+	defer cancel()
+	err = next(tctx, request, response)
+	if err == nil && tctx.Err() == context.DeadlineExceeded {
+		err = tctx.Err()
+	}
+	return err, errors.Is(err, context.DeadlineExceeded) && tctx.Err() == context.DeadlineExceeded
+}
+
+// tripped produces the error the middleware returns when a request is denied
+// because the breaker is open, in priority order: a registered fallback service
+// (FallbackTarget/FallbackRegistry), then Fallback, then DecorateError.
+func (c *Control) tripped(ctx context.Context, request, response interface{}, tripErr error) error {
+	if dispatched, err := c.dispatchFallback(ctx, request, response); dispatched {
+		return err
+	}
+	if c.Fallback != nil {
+		return c.Fallback(ctx, request, response, tripErr)
+	}
+	return c.DecorateError(ctx, request, tripErr)
+}
+
+// invokeAndClassify runs next, honoring InvocationTimeout, and classifies the
+// outcome. A call cut short by InvocationTimeout is always classified as
+// TypeTimeout, regardless of what GetErrorType would otherwise say, and has its
+// error replaced by Fallback's result when Fallback is set.
+func (c *Control) invokeAndClassify(ctx context.Context, next endpoint.Endpoint, request, response interface{}) (err error, errType ErrorType) {
+	err, timedOut := c.invoke(ctx, next, request, response)
+	if timedOut {
+		if c.Fallback != nil {
+			err = c.Fallback(ctx, request, response, err)
+		}
+		return err, TypeTimeout
+	}
+	return err, c.GetErrorType(ctx, request, response, err)
+}
+
+func (c *Control) observe(key string, latency time.Duration, errType ErrorType, response interface{}) {
+	if c.TripCondition == nil {
+		return
+	}
+	obs := Observation{Latency: latency, ErrType: errType}
+	if c.ClassifyResponse != nil {
+		obs.StatusCode, obs.HasStatusCode = c.ClassifyResponse(response)
+	}
+	c.TripCondition.Observe(key, obs)
+}
+
+// tripConditionOpen reports whether TripCondition, if any, considers key open.
+func (c *Control) tripConditionOpen(key string) bool {
+	if c.TripCondition == nil {
+		return false
+	}
+	trip, enough := c.TripCondition.ShouldTrip(key)
+	return enough && trip
 }
 
 const cbTickDuration = 1 * time.Second
@@ -105,49 +222,65 @@ func (t *cbTicker) Tick() {
 }
 
 // NewCircuitBreakerMW creates a circuit breaker MW using the given Control strategy and Panel.
+// Once the fast path below has bypassed its own Panel-specific bookkeeping, it delegates to
+// the same core loop NewCircuitBreakerMWWithBackend uses, via NewPanelBackend(panel), so the
+// two entry points cannot drift apart on which Control features (TripCondition, StateMachine,
+// InvocationTimeout, Fallback, CancelInflightOnOpen, FallbackTarget) they honor.
 func NewCircuitBreakerMW(control Control, panel circuitbreaker.Panel) endpoint.Middleware {
 	var hasOpen int32
+	core := newBreakerCore(control, NewPanelBackend(panel))
 	return func(next endpoint.Endpoint) endpoint.Endpoint {
 		return func(ctx context.Context, request, response interface{}) (err error) {
-			// If circuit breaker is not enabled, just bypass it.
-			if atomic.LoadInt32(&hasOpen) == 0 {
-				err = next(ctx, request, response)
-				// Disable the bypass circuit breaker feature when encountering an error.
-				if isErr := err != nil && IsError(control.GetErrorType(ctx, request, response, err)); isErr ||
-					atomic.LoadInt32(&hasOpen) != 0 {
-					key, enabled := control.GetKey(ctx, request)
-					if enabled {
-						if isErr && atomic.CompareAndSwapInt32(&hasOpen, 0, 1) {
+			// If circuit breaker is not enabled, just bypass it. This bypass only applies
+			// when StateMachine is nil: StateMachine tracks each key's real state directly,
+			// independent of the Panel, so honoring it here too would let a key StateMachine
+			// has tripped keep receiving full traffic until the ticker below (which only
+			// watches Panel.DumpBreakers, something StateMachine never touches) happens to
+			// notice a failure and turn the bypass off.
+			if control.StateMachine == nil && atomic.LoadInt32(&hasOpen) == 0 {
+				start := time.Now()
+				var errType ErrorType
+				err, errType = control.invokeAndClassify(ctx, next, request, response)
+				isErr := err != nil && IsError(errType)
+				key, enabled := control.GetKey(ctx, request)
+				if enabled {
+					// Observe every call, success or failure: a latency- or status-based
+					// TripCondition (e.g. LatencyAtQuantile) must accumulate samples from
+					// passing calls too, or it can never trip on its own before something
+					// has already failed once.
+					control.observe(key, time.Since(start), errType, response)
+					conditionTripped := control.tripConditionOpen(key)
+					// Disable the bypass circuit breaker feature when encountering an error
+					// or a trip condition breach.
+					if isErr || conditionTripped || atomic.LoadInt32(&hasOpen) != 0 {
+						if atomic.CompareAndSwapInt32(&hasOpen, 0, 1) {
 							// Start a ticker to asynchronously judge whether the circuit breaker has been closed.
 							sharedTicker.Add(&cbTicker{
 								panel:   panel,
 								hasOpen: &hasOpen,
 							})
 						}
-						RecordStat(ctx, request, response, err, key, &control, panel)
+						recordStatWithType(errType, key, panel)
 					}
 				}
 				return
 			}
-			key, enabled := control.GetKey(ctx, request)
-			if !enabled {
-				return next(ctx, request, response)
-			}
-
-			if !panel.IsAllowed(key) {
-				return control.DecorateError(ctx, request, kerrors.ErrCircuitBreak)
-			}
-
-			err = next(ctx, request, response)
-			RecordStat(ctx, request, response, err, key, &control, panel)
-			return
+			return core(ctx, next, request, response)
 		}
 	}
 }
 
 // RecordStat to report request result to circuit breaker
 func RecordStat(ctx context.Context, request, response interface{}, err error, cbKey string, ctl *Control, panel circuitbreaker.Panel) {
-	switch ctl.GetErrorType(ctx, request, response, err) {
+	recordStatWithType(ctl.GetErrorType(ctx, request, response, err), cbKey, panel)
+}
+
+// recordStatWithType is like RecordStat but takes an already-classified ErrorType,
+// used internally where the middleware has forced a classification (e.g. a call
+// cut short by InvocationTimeout always counts as TypeTimeout) that re-running
+// GetErrorType would not reproduce.
+func recordStatWithType(errType ErrorType, cbKey string, panel circuitbreaker.Panel) {
+	switch errType {
 	case TypeTimeout:
 		panel.Timeout(cbKey)
 	case TypeFailure:
@@ -157,6 +290,130 @@ func RecordStat(ctx context.Context, request, response interface{}, err error, c
 	}
 }
 
+// NewCircuitBreakerMWWithBackend creates a circuit breaker MW like NewCircuitBreakerMW,
+// but against the pluggable Backend interface instead of a hard-coded circuitbreaker.Panel.
+// This lets the breaker bookkeeping be delegated to another library, e.g. sony/gobreaker
+// or afex/hystrix-go, via the adapters in the circuitbreak/gobreakeradapter and
+// circuitbreak/hystrixadapter subpackages. Wrap an existing Panel with NewPanelBackend
+// to keep using the default bytedance/gopkg implementation through this entry point.
+// It shares its admission/invoke/report loop with NewCircuitBreakerMW (see newBreakerCore),
+// so every Control feature - TripCondition, StateMachine, InvocationTimeout, Fallback,
+// CancelInflightOnOpen, FallbackTarget - works the same way regardless of which Backend
+// is plugged in.
+func NewCircuitBreakerMWWithBackend(control Control, backend Backend) endpoint.Middleware {
+	core := newBreakerCore(control, backend)
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request, response interface{}) (err error) {
+			return core(ctx, next, request, response)
+		}
+	}
+}
+
+// newBreakerCore builds the admission/invoke/report loop shared by NewCircuitBreakerMW
+// (once its Panel-specific fast path has bypassed its own bookkeeping) and
+// NewCircuitBreakerMWWithBackend, so the two entry points cannot silently drift apart on
+// which Control features they support.
+func newBreakerCore(control Control, backend Backend) func(ctx context.Context, next endpoint.Endpoint, request, response interface{}) error {
+	var registry *inflightRegistry
+	if control.CancelInflightOnOpen {
+		registry = newInflightRegistry()
+	}
+	if control.StateMachine != nil {
+		userHook := control.StateChangeHook
+		control.StateMachine.Hook = func(key string, from, to State, reason error) {
+			if registry != nil && to == Open {
+				registry.cancelAll(key)
+			}
+			if userHook != nil {
+				userHook(key, from, to, reason)
+			}
+		}
+	}
+
+	return func(ctx context.Context, next endpoint.Endpoint, request, response interface{}) (err error) {
+		key, enabled := control.GetKey(ctx, request)
+		if !enabled {
+			return next(ctx, request, response)
+		}
+
+		allowed, generation := backend.IsAllowed(key)
+		blocked := !allowed || control.tripConditionOpen(key)
+		if control.StateMachine != nil {
+			blocked = !control.StateMachine.Allow(key)
+		}
+		if blocked {
+			if allowed {
+				// backend.IsAllowed already allocated generation (e.g.
+				// gobreakeradapter parked its Execute goroutine waiting on it) before
+				// tripConditionOpen/StateMachine.Allow decided to reject the call
+				// anyway; resolve it here or it leaks forever.
+				reportOutcome(backend, key, generation, TypeFailure)
+			}
+			return control.tripped(ctx, request, response, kerrors.ErrCircuitBreak)
+		}
+
+		callCtx := ctx
+		var cancelled *int32
+		if registry != nil {
+			var cancel context.CancelFunc
+			callCtx, cancel = context.WithCancel(ctx)
+			cancelled = new(int32)
+			unregister := registry.register(key, func() {
+				atomic.StoreInt32(cancelled, 1)
+				cancel()
+			})
+			defer unregister()
+		}
+
+		start := time.Now()
+		errType := TypeFailure
+		defer func() {
+			if r := recover(); r != nil {
+				// A panic here would otherwise skip reportOutcome below, leaking any
+				// adapter (e.g. gobreakeradapter) parked waiting on this generation's
+				// outcome forever. Resolve it as a failure, then keep unwinding.
+				reportOutcome(backend, key, generation, TypeFailure)
+				panic(r)
+			}
+		}()
+		err, errType = control.invokeAndClassify(callCtx, next, request, response)
+		if cancelled != nil && atomic.LoadInt32(cancelled) != 0 {
+			// This request was cancelled because its key tripped open while it was in
+			// flight; report it the same way a rejected request would be.
+			reportOutcome(backend, key, generation, TypeFailure)
+			return control.tripped(ctx, request, response, kerrors.ErrCircuitBreak)
+		}
+		control.observe(key, time.Since(start), errType, response)
+		if control.StateMachine != nil {
+			// Report only acts on a HalfOpen key (a failed probe reopens it); a
+			// Closed key that just failed, or breached a TripCondition, never
+			// trips on its own without an explicit Trip call.
+			if control.StateMachine.State(key) == Closed && (IsError(errType) || control.tripConditionOpen(key)) {
+				control.StateMachine.Trip(key, err)
+			}
+			control.StateMachine.Report(key, !IsError(errType))
+		}
+		reportOutcome(backend, key, generation, errType)
+		return
+	}
+}
+
+// reportOutcome resolves backend's bookkeeping for key/generation according to errType.
+// TypeIgnorable, and anything else the switch below doesn't special-case, resolves as a
+// success: the call must not count against the breaker, but an adapter parked waiting
+// for an outcome (e.g. gobreakeradapter's Execute goroutine) still needs to be unblocked,
+// or its pending generation leaks forever.
+func reportOutcome(backend Backend, key string, generation Generation, errType ErrorType) {
+	switch errType {
+	case TypeTimeout:
+		backend.Timeout(key, generation)
+	case TypeFailure:
+		backend.Fail(key, generation)
+	default:
+		backend.Succeed(key, generation)
+	}
+}
+
 // CircuitBreakerAwareError is used to wrap ErrorType
 type CircuitBreakerAwareError interface {
 	error
@@ -0,0 +1,98 @@
+/*
+ * Copyright 2023 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package circuitbreak
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/cloudwego/kitex/pkg/kerrors"
+)
+
+// noopBackend always allows and never blocks on its own; these tests are only
+// interested in StateMachine's own Closed/Open bookkeeping.
+type noopBackend struct{}
+
+func (noopBackend) IsAllowed(string) (bool, Generation) { return true, 0 }
+func (noopBackend) Succeed(string, Generation)          {}
+func (noopBackend) Fail(string, Generation)             {}
+func (noopBackend) Timeout(string, Generation)          {}
+func (noopBackend) State(string) State                  { return Closed }
+func (noopBackend) Subscribe(func(key string, from, to State)) {}
+
+func testControl(sm *StateMachine) Control {
+	return Control{
+		GetKey: func(ctx context.Context, request interface{}) (string, bool) {
+			return "svc.Method", true
+		},
+		GetErrorType: func(ctx context.Context, request, response interface{}, err error) ErrorType {
+			if err != nil {
+				return TypeFailure
+			}
+			return TypeSuccess
+		},
+		DecorateError: func(ctx context.Context, request interface{}, err error) error {
+			return err
+		},
+		StateMachine: sm,
+	}
+}
+
+func TestNewCircuitBreakerMWWithBackend_ClosedKeyTripsOpenOnFailure(t *testing.T) {
+	sm := NewStateMachine(time.Hour, 1, 1, nil)
+	control := testControl(sm)
+	mw := NewCircuitBreakerMWWithBackend(control, noopBackend{})
+
+	failing := func(ctx context.Context, request, response interface{}) error {
+		return errors.New("boom")
+	}
+	ep := mw(failing)
+
+	if err := ep(context.Background(), nil, nil); err == nil {
+		t.Fatal("expected the first, failing call to return its own error")
+	}
+	if state := sm.State("svc.Method"); state != Open {
+		t.Fatalf("expected key to trip Open after a Closed-key failure, got state %v", state)
+	}
+
+	// The key is now Open with an hour-long SleepWindow, so the very next call
+	// must be rejected by the breaker itself rather than reaching the endpoint.
+	err := ep(context.Background(), nil, nil)
+	if !errors.Is(err, kerrors.ErrCircuitBreak) {
+		t.Fatalf("expected kerrors.ErrCircuitBreak once the key is Open, got %v", err)
+	}
+}
+
+func TestNewCircuitBreakerMWWithBackend_ClosedKeyStaysClosedOnSuccess(t *testing.T) {
+	sm := NewStateMachine(time.Hour, 1, 1, nil)
+	control := testControl(sm)
+	mw := NewCircuitBreakerMWWithBackend(control, noopBackend{})
+
+	ok := func(ctx context.Context, request, response interface{}) error { return nil }
+	ep := mw(ok)
+
+	for i := 0; i < 3; i++ {
+		if err := ep(context.Background(), nil, nil); err != nil {
+			t.Fatalf("call %d: unexpected error %v", i, err)
+		}
+	}
+	if state := sm.State("svc.Method"); state != Closed {
+		t.Fatalf("expected key to remain Closed after only successful calls, got state %v", state)
+	}
+}
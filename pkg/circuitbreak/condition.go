@@ -0,0 +1,392 @@
+/*
+ * Copyright 2023 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package circuitbreak
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/HdrHistogram/hdrhistogram-go"
+)
+
+// Observation is one call's outcome, fed into a TripCondition's rolling window.
+type Observation struct {
+	// Latency is measured from just before next() is invoked to just after it returns.
+	Latency time.Duration
+	// ErrType is the ErrorType Control.GetErrorType classified this call as.
+	ErrType ErrorType
+	// StatusCode is the result of classifying the response with Control.ClassifyResponse.
+	// HasStatusCode is false when no classifier was configured, or it declined to classify.
+	StatusCode    int
+	HasStatusCode bool
+}
+
+// TripCondition decides, from a rolling window of Observations for a single key,
+// whether that key should trip open. Conditions are evaluated independently per key;
+// implementations must be safe for concurrent use by multiple keys sharing one
+// TripCondition instance.
+type TripCondition interface {
+	// Observe records one call's outcome for key.
+	Observe(key string, obs Observation)
+	// ShouldTrip reports whether key's window has breached the condition's threshold.
+	// It also reports whether enough samples have been collected to judge at all;
+	// when enough is false, the key must not trip on this condition alone.
+	ShouldTrip(key string) (trip bool, enough bool)
+}
+
+// window is a fixed-size ring of per-second buckets, used by the ratio-based
+// conditions to keep a rolling count over the configured duration.
+type window struct {
+	mu      sync.Mutex
+	buckets []windowBucket
+	size    int
+	last    int64 // unix seconds of the last bucket written
+}
+
+type windowBucket struct {
+	unixSec int64
+	total   int64
+	match   int64
+}
+
+func newWindow(d time.Duration) *window {
+	size := int(d / time.Second)
+	if size < 1 {
+		size = 1
+	}
+	return &window{buckets: make([]windowBucket, size), size: size}
+}
+
+func (w *window) add(nowSec int64, isMatch bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	idx := int(nowSec % int64(w.size))
+	if w.buckets[idx].unixSec != nowSec {
+		w.buckets[idx] = windowBucket{unixSec: nowSec}
+	}
+	w.buckets[idx].total++
+	if isMatch {
+		w.buckets[idx].match++
+	}
+	w.last = nowSec
+}
+
+func (w *window) ratio(nowSec int64) (ratio float64, total int64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var match int64
+	cutoff := nowSec - int64(w.size)
+	for _, b := range w.buckets {
+		if b.unixSec > cutoff && b.unixSec <= nowSec {
+			total += b.total
+			match += b.match
+		}
+	}
+	if total == 0 {
+		return 0, 0
+	}
+	return float64(match) / float64(total), total
+}
+
+func nowUnix() int64 { return time.Now().Unix() }
+
+// latencyQuantileCondition trips when the qUS-th quantile (e.g. 50.0 for p50, 99.0
+// for p99) of observed latency, in milliseconds, over window exceeds thresholdMS.
+type latencyQuantileCondition struct {
+	qUS         float64
+	thresholdMS int64
+	window      time.Duration
+	minimal     int64
+
+	mu   sync.Mutex
+	hist map[string]*quantileBucket
+}
+
+type quantileBucket struct {
+	mu         sync.Mutex
+	h          *hdrhistogram.Histogram
+	lastReset  int64
+	windowSecs int64
+}
+
+// record adds a latency observation, in microseconds, to the bucket's histogram.
+// hdrhistogram.Histogram is not safe for concurrent use, so this must hold mu for
+// both recording and reading, the same way window guards its own buckets.
+func (b *quantileBucket) record(us int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	_ = b.h.RecordValue(us)
+}
+
+// quantile reports the observed count and the value at q, both under mu so they
+// reflect a single consistent snapshot of the histogram.
+func (b *quantileBucket) quantile(q float64) (count int64, valueUS int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.h.TotalCount(), b.h.ValueAtQuantile(q)
+}
+
+// LatencyAtQuantile trips when the qUS-th latency percentile over the trailing
+// window exceeds thresholdMS milliseconds, once minimalSample observations have
+// landed in the current window. A fresh HDR histogram per key is rotated every
+// window so the quantile always reflects recent behavior, not all-time history.
+func LatencyAtQuantile(qUS float64, thresholdMS int64, window time.Duration, minimalSample int64) TripCondition {
+	return &latencyQuantileCondition{
+		qUS:         qUS,
+		thresholdMS: thresholdMS,
+		window:      window,
+		minimal:     minimalSample,
+		hist:        map[string]*quantileBucket{},
+	}
+}
+
+func (c *latencyQuantileCondition) bucketFor(key string) *quantileBucket {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := nowUnix()
+	b, ok := c.hist[key]
+	if !ok || now-b.lastReset >= b.windowSecs {
+		b = &quantileBucket{
+			h:          hdrhistogram.New(1, int64(time.Minute/time.Microsecond), 3),
+			lastReset:  now,
+			windowSecs: int64(c.window / time.Second),
+		}
+		if b.windowSecs < 1 {
+			b.windowSecs = 1
+		}
+		c.hist[key] = b
+	}
+	return b
+}
+
+func (c *latencyQuantileCondition) Observe(key string, obs Observation) {
+	c.bucketFor(key).record(obs.Latency.Microseconds())
+}
+
+func (c *latencyQuantileCondition) ShouldTrip(key string) (bool, bool) {
+	count, quantileUS := c.bucketFor(key).quantile(c.qUS)
+	if count < c.minimal {
+		return false, false
+	}
+	return quantileUS > c.thresholdMS*1000, true
+}
+
+// ratioCondition is shared plumbing for NetworkErrorRatio and ResponseCodeRatio.
+type ratioCondition struct {
+	threshold float64
+	minimal   int64
+	windowDur time.Duration
+	matches   func(obs Observation) bool
+
+	mu      sync.Mutex
+	windows map[string]*window
+}
+
+func newRatioCondition(threshold float64, windowDur time.Duration, minimalSample int64, matches func(Observation) bool) *ratioCondition {
+	return &ratioCondition{
+		threshold: threshold,
+		minimal:   minimalSample,
+		windowDur: windowDur,
+		matches:   matches,
+		windows:   map[string]*window{},
+	}
+}
+
+func (c *ratioCondition) windowFor(key string) *window {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	w, ok := c.windows[key]
+	if !ok {
+		w = newWindow(c.windowDur)
+		c.windows[key] = w
+	}
+	return w
+}
+
+func (c *ratioCondition) Observe(key string, obs Observation) {
+	c.windowFor(key).add(nowUnix(), c.matches(obs))
+}
+
+func (c *ratioCondition) ShouldTrip(key string) (bool, bool) {
+	ratio, total := c.windowFor(key).ratio(nowUnix())
+	if total < c.minimal {
+		return false, false
+	}
+	return ratio > c.threshold, true
+}
+
+// NetworkErrorRatio trips when the fraction of calls classified as TypeTimeout or
+// TypeFailure over window exceeds threshold, once minimalSample calls have landed.
+func NetworkErrorRatio(threshold float64, window time.Duration, minimalSample int64) TripCondition {
+	return newRatioCondition(threshold, window, minimalSample, func(obs Observation) bool {
+		return obs.ErrType == TypeTimeout || obs.ErrType == TypeFailure
+	})
+}
+
+// ResponseCodeRatio trips when the fraction of calls whose classified status code
+// falls within [minCode, maxCode] over window exceeds threshold, once minimalSample
+// classified calls have landed. Calls the response classifier declined to classify
+// do not count toward the sample.
+func ResponseCodeRatio(minCode, maxCode int, threshold float64, window time.Duration, minimalSample int64) TripCondition {
+	return classifiedRatioCondition{newRatioCondition(threshold, window, minimalSample, func(obs Observation) bool {
+		return obs.StatusCode >= minCode && obs.StatusCode <= maxCode
+	})}
+}
+
+// classifiedRatioCondition drops observations without a status code before they
+// reach the window, so they count toward neither the sample nor the ratio.
+type classifiedRatioCondition struct {
+	*ratioCondition
+}
+
+func (c classifiedRatioCondition) Observe(key string, obs Observation) {
+	if obs.HasStatusCode {
+		c.ratioCondition.Observe(key, obs)
+	}
+}
+
+// boolCombinator composes TripConditions with a boolean operator.
+type boolCombinator struct {
+	conditions []TripCondition
+	all        bool // true for And, false for Or
+}
+
+func (c *boolCombinator) Observe(key string, obs Observation) {
+	for _, cond := range c.conditions {
+		cond.Observe(key, obs)
+	}
+}
+
+func (c *boolCombinator) ShouldTrip(key string) (trip bool, enough bool) {
+	for _, cond := range c.conditions {
+		t, e := cond.ShouldTrip(key)
+		if !e {
+			if c.all {
+				// And cannot be judged until every one of its conditions can.
+				return false, false
+			}
+			// Or is judged on whichever of its conditions have enough samples.
+			continue
+		}
+		if !enough {
+			trip, enough = t, true
+		} else if c.all {
+			trip = trip && t
+		} else {
+			trip = trip || t
+		}
+	}
+	return trip, enough
+}
+
+// And trips only once every condition trips.
+func And(conditions ...TripCondition) TripCondition {
+	return &boolCombinator{conditions: conditions, all: true}
+}
+
+// Or trips once any condition with enough samples trips.
+func Or(conditions ...TripCondition) TripCondition {
+	return &boolCombinator{conditions: conditions, all: false}
+}
+
+// ParseExpression parses a small boolean expression language over the built-in
+// conditions, similar to Traefik's cbreaker expressions, e.g.:
+//
+//	LatencyAtQuantileMS(50.0) > 100 || NetworkErrorRatio() > 0.5
+//
+// Supported terms are LatencyAtQuantileMS(q), NetworkErrorRatio(), and
+// ResponseCodeRatio(min, max), compared with > against a threshold, combined with
+// && / ||. window and minimalSample are applied to every term the expression
+// produces, since the expression language itself carries no window syntax.
+func ParseExpression(expr string, window time.Duration, minimalSample int64) (TripCondition, error) {
+	expr = strings.TrimSpace(expr)
+	if strings.Contains(expr, "||") {
+		parts := strings.Split(expr, "||")
+		conds := make([]TripCondition, 0, len(parts))
+		for _, p := range parts {
+			c, err := ParseExpression(p, window, minimalSample)
+			if err != nil {
+				return nil, err
+			}
+			conds = append(conds, c)
+		}
+		return Or(conds...), nil
+	}
+	if strings.Contains(expr, "&&") {
+		parts := strings.Split(expr, "&&")
+		conds := make([]TripCondition, 0, len(parts))
+		for _, p := range parts {
+			c, err := ParseExpression(p, window, minimalSample)
+			if err != nil {
+				return nil, err
+			}
+			conds = append(conds, c)
+		}
+		return And(conds...), nil
+	}
+	return parseTerm(strings.TrimSpace(expr), window, minimalSample)
+}
+
+func parseTerm(term string, window time.Duration, minimalSample int64) (TripCondition, error) {
+	idx := strings.Index(term, ">")
+	if idx < 0 {
+		return nil, fmt.Errorf("circuitbreak: unsupported expression term %q, expected a \">\" comparison", term)
+	}
+	call := strings.TrimSpace(term[:idx])
+	thresholdStr := strings.TrimSpace(term[idx+1:])
+	threshold, err := strconv.ParseFloat(thresholdStr, 64)
+	if err != nil {
+		return nil, fmt.Errorf("circuitbreak: invalid threshold in %q: %w", term, err)
+	}
+
+	open := strings.Index(call, "(")
+	if open < 0 || !strings.HasSuffix(call, ")") {
+		return nil, fmt.Errorf("circuitbreak: unsupported expression term %q", term)
+	}
+	name := strings.TrimSpace(call[:open])
+	args := strings.TrimSpace(call[open+1 : len(call)-1])
+
+	switch name {
+	case "LatencyAtQuantileMS":
+		q, err := strconv.ParseFloat(args, 64)
+		if err != nil {
+			return nil, fmt.Errorf("circuitbreak: invalid quantile in %q: %w", term, err)
+		}
+		return LatencyAtQuantile(q, int64(threshold), window, minimalSample), nil
+	case "NetworkErrorRatio":
+		return NetworkErrorRatio(threshold, window, minimalSample), nil
+	case "ResponseCodeRatio":
+		bounds := strings.Split(args, ",")
+		if len(bounds) != 2 {
+			return nil, fmt.Errorf("circuitbreak: ResponseCodeRatio expects (min, max), got %q", args)
+		}
+		minCode, err := strconv.Atoi(strings.TrimSpace(bounds[0]))
+		if err != nil {
+			return nil, fmt.Errorf("circuitbreak: invalid min code in %q: %w", term, err)
+		}
+		maxCode, err := strconv.Atoi(strings.TrimSpace(bounds[1]))
+		if err != nil {
+			return nil, fmt.Errorf("circuitbreak: invalid max code in %q: %w", term, err)
+		}
+		return ResponseCodeRatio(minCode, maxCode, threshold, window, minimalSample), nil
+	default:
+		return nil, fmt.Errorf("circuitbreak: unknown expression function %q", name)
+	}
+}
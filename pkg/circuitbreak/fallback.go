@@ -0,0 +1,141 @@
+/*
+ * Copyright 2023 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package circuitbreak
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/cloudwego/kitex/pkg/serviceinfo"
+)
+
+// fallbackEntry pairs a registered ServiceInfo with the handler implementing it,
+// mirroring server.service.
+type fallbackEntry struct {
+	svcInfo *serviceinfo.ServiceInfo
+	handler interface{}
+}
+
+// FallbackRegistry holds the locally registered degraded-mode implementations a
+// client-side circuit breaker can dispatch to when panel.IsAllowed(key) is false.
+// Its method resolution rules mirror server.services.addService: a method name
+// conflicting across multiple registered services resolves to whichever service
+// was registered as the fallback, exactly as the server resolves fallbackSvc.
+type FallbackRegistry struct {
+	mu                                 sync.RWMutex
+	svcSearchMap                       map[string]*fallbackEntry // key: "svcName.methodName" and "methodName"
+	svcMap                             map[string]*fallbackEntry // key: service name
+	conflictingMethodHasFallbackSvcMap map[string]bool
+	fallbackSvc                        *fallbackEntry
+}
+
+// NewFallbackRegistry creates an empty FallbackRegistry.
+func NewFallbackRegistry() *FallbackRegistry {
+	return &FallbackRegistry{
+		svcSearchMap:                       map[string]*fallbackEntry{},
+		svcMap:                             map[string]*fallbackEntry{},
+		conflictingMethodHasFallbackSvcMap: map[string]bool{},
+	}
+}
+
+// Register adds a locally implemented service as a fallback candidate. isFallback
+// marks it as THE fallback service: when a method name is ambiguous across
+// multiple registered services, the fallback service wins, same as a server-side
+// fallback service resolves a conflicting method in services.addService.
+func (r *FallbackRegistry) Register(svcInfo *serviceinfo.ServiceInfo, handler interface{}, isFallback bool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	entry := &fallbackEntry{svcInfo: svcInfo, handler: handler}
+
+	if isFallback {
+		if r.fallbackSvc != nil {
+			return fmt.Errorf("multiple fallback services cannot be registered. [%s] is already registered as a fallback service", r.fallbackSvc.svcInfo.ServiceName)
+		}
+		r.fallbackSvc = entry
+	}
+	r.svcMap[svcInfo.ServiceName] = entry
+	for methodName := range svcInfo.Methods {
+		r.svcSearchMap[fmt.Sprintf("%s.%s", svcInfo.ServiceName, methodName)] = entry
+		if existing, ok := r.svcSearchMap[methodName]; ok {
+			if _, seen := r.conflictingMethodHasFallbackSvcMap[methodName]; !seen {
+				r.conflictingMethodHasFallbackSvcMap[methodName] = r.fallbackSvc != nil && existing.svcInfo.ServiceName == r.fallbackSvc.svcInfo.ServiceName
+			}
+			if isFallback {
+				r.svcSearchMap[methodName] = entry
+				r.conflictingMethodHasFallbackSvcMap[methodName] = true
+			}
+		} else {
+			r.svcSearchMap[methodName] = entry
+		}
+	}
+	return nil
+}
+
+// lookup resolves (svcName, methodName) the same way the server's svcSearchMap
+// does: an exact "svcName.methodName" match first, then a bare methodName match.
+func (r *FallbackRegistry) lookup(svcName, methodName string) (*serviceinfo.ServiceInfo, interface{}, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if entry, ok := r.svcSearchMap[fmt.Sprintf("%s.%s", svcName, methodName)]; ok {
+		return entry.svcInfo, entry.handler, true
+	}
+	if entry, ok := r.svcSearchMap[methodName]; ok {
+		return entry.svcInfo, entry.handler, true
+	}
+	return nil, nil, false
+}
+
+// FallbackInvoke bridges FallbackRegistry's generic (ServiceInfo, handler) pair to
+// the generated handler call for methodName, so circuitbreak does not need to know
+// the exact shape of serviceinfo.MethodInfo's handler signature.
+type FallbackInvoke func(ctx context.Context, svcInfo *serviceinfo.ServiceInfo, handler interface{}, methodName string, request, response interface{}) error
+
+// DefaultFallbackInvoke looks up methodName on svcInfo and runs its generated
+// handler against request/response, the same call generated client/server code
+// makes for a normal RPC.
+func DefaultFallbackInvoke(ctx context.Context, svcInfo *serviceinfo.ServiceInfo, handler interface{}, methodName string, request, response interface{}) error {
+	mi, ok := svcInfo.Methods[methodName]
+	if !ok {
+		return fmt.Errorf("circuitbreak: fallback service %q has no method %q", svcInfo.ServiceName, methodName)
+	}
+	return mi.Handler()(ctx, handler, request, response)
+}
+
+// dispatchFallback resolves and invokes a registered fallback for request, if
+// FallbackTarget and FallbackRegistry are both configured and FallbackTarget opts
+// this request in. ok is false when no fallback applies, in which case the caller
+// should fall through to its normal open-circuit handling.
+func (c *Control) dispatchFallback(ctx context.Context, request, response interface{}) (ok bool, err error) {
+	if c.FallbackTarget == nil || c.FallbackRegistry == nil {
+		return false, nil
+	}
+	svcName, methodName, enabled := c.FallbackTarget(ctx, request)
+	if !enabled {
+		return false, nil
+	}
+	svcInfo, handler, found := c.FallbackRegistry.lookup(svcName, methodName)
+	if !found {
+		return false, nil
+	}
+	invoke := c.FallbackInvoke
+	if invoke == nil {
+		invoke = DefaultFallbackInvoke
+	}
+	return true, invoke(ctx, svcInfo, handler, methodName, request, response)
+}
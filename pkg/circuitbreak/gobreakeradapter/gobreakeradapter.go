@@ -0,0 +1,184 @@
+/*
+ * Copyright 2023 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package gobreakeradapter adapts github.com/sony/gobreaker, a Google SRE-style
+// consecutive-failure breaker, to the circuitbreak.Backend interface so it can be
+// passed to circuitbreak.NewCircuitBreakerMWWithBackend.
+package gobreakeradapter
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+
+	"github.com/sony/gobreaker"
+
+	"github.com/cloudwego/kitex/pkg/circuitbreak"
+)
+
+// errCallFailed is reported to gobreaker for Fail/Timeout outcomes; only its
+// non-nilness matters to gobreaker's ReadyToTrip accounting.
+var errCallFailed = errors.New("gobreakeradapter: call failed")
+
+// SettingsFactory builds the gobreaker.Settings to use for a given key, the first
+// time that key is observed. Name is overwritten with key; OnStateChange is wrapped,
+// not replaced, so Backend.Subscribe keeps working alongside a user-supplied hook.
+type SettingsFactory func(key string) gobreaker.Settings
+
+// Adapter implements circuitbreak.Backend on top of one gobreaker.CircuitBreaker per
+// key. Because gobreaker's API is built around executing the call inside cb.Execute,
+// while circuitbreak.Backend splits "may I proceed" (IsAllowed) from "here's what
+// happened" (Succeed/Fail/Timeout) around the middleware's own call to next(), each
+// admitted request runs its Execute closure in a background goroutine that blocks
+// until the matching outcome call arrives for its generation.
+type Adapter struct {
+	newSettings SettingsFactory
+
+	mu       sync.Mutex
+	breakers map[string]*gobreaker.CircuitBreaker
+
+	nextGen int64
+	pending sync.Map // circuitbreak.Generation -> chan error
+
+	listeners []func(key string, from, to circuitbreak.State)
+}
+
+// New creates an Adapter. newSettings is used lazily to construct the
+// gobreaker.CircuitBreaker for each newly observed key.
+func New(newSettings SettingsFactory) *Adapter {
+	return &Adapter{
+		newSettings: newSettings,
+		breakers:    map[string]*gobreaker.CircuitBreaker{},
+	}
+}
+
+func (a *Adapter) breakerFor(key string) *gobreaker.CircuitBreaker {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if cb, ok := a.breakers[key]; ok {
+		return cb
+	}
+	settings := a.newSettings(key)
+	settings.Name = key
+	userOnStateChange := settings.OnStateChange
+	settings.OnStateChange = func(name string, from, to gobreaker.State) {
+		if userOnStateChange != nil {
+			userOnStateChange(name, from, to)
+		}
+		a.notify(name, toState(from), toState(to))
+	}
+	cb := gobreaker.NewCircuitBreaker(settings)
+	a.breakers[key] = cb
+	return cb
+}
+
+func (a *Adapter) notify(key string, from, to circuitbreak.State) {
+	a.mu.Lock()
+	listeners := append([]func(key string, from, to circuitbreak.State){}, a.listeners...)
+	a.mu.Unlock()
+	for _, fn := range listeners {
+		fn(key, from, to)
+	}
+}
+
+func toState(s gobreaker.State) circuitbreak.State {
+	switch s {
+	case gobreaker.StateOpen:
+		return circuitbreak.Open
+	case gobreaker.StateHalfOpen:
+		return circuitbreak.HalfOpen
+	default:
+		return circuitbreak.Closed
+	}
+}
+
+// IsAllowed starts a gobreaker.Execute call on a background goroutine and waits for
+// it to either be let through (the closure starts running, and is parked waiting for
+// the outcome) or rejected outright (gobreaker.ErrOpenState / ErrTooManyRequests).
+func (a *Adapter) IsAllowed(key string) (bool, circuitbreak.Generation) {
+	cb := a.breakerFor(key)
+	started := make(chan struct{})
+	outcome := make(chan error, 1)
+	rejected := make(chan struct{}, 1)
+
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				// A panic before close(started) would otherwise leave IsAllowed's
+				// select blocked forever; report it as a rejection so the caller
+				// gets an answer instead of hanging. A panic after close(started)
+				// has already returned true with a generation, so there is nothing
+				// left to unblock here - this only keeps the goroutine from
+				// crashing the whole process.
+				select {
+				case rejected <- struct{}{}:
+				default:
+				}
+			}
+		}()
+		_, err := cb.Execute(func() (interface{}, error) {
+			close(started)
+			return nil, <-outcome
+		})
+		if err == gobreaker.ErrOpenState || err == gobreaker.ErrTooManyRequests {
+			rejected <- struct{}{}
+		}
+	}()
+
+	select {
+	case <-started:
+		gen := circuitbreak.Generation(atomic.AddInt64(&a.nextGen, 1))
+		a.pending.Store(gen, outcome)
+		return true, gen
+	case <-rejected:
+		return false, 0
+	}
+}
+
+func (a *Adapter) resolve(generation circuitbreak.Generation, err error) {
+	v, ok := a.pending.LoadAndDelete(generation)
+	if !ok {
+		return
+	}
+	v.(chan error) <- err
+}
+
+// Succeed resolves the pending call for generation with a nil error.
+func (a *Adapter) Succeed(_ string, generation circuitbreak.Generation) {
+	a.resolve(generation, nil)
+}
+
+// Fail resolves the pending call for generation with a non-nil error.
+func (a *Adapter) Fail(_ string, generation circuitbreak.Generation) {
+	a.resolve(generation, errCallFailed)
+}
+
+// Timeout resolves the pending call for generation, counted the same as a failure.
+func (a *Adapter) Timeout(key string, generation circuitbreak.Generation) {
+	a.Fail(key, generation)
+}
+
+// State returns the current gobreaker state for key.
+func (a *Adapter) State(key string) circuitbreak.State {
+	return toState(a.breakerFor(key).State())
+}
+
+// Subscribe registers fn to be called on every state transition, across all keys.
+func (a *Adapter) Subscribe(fn func(key string, from, to circuitbreak.State)) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.listeners = append(a.listeners, fn)
+}
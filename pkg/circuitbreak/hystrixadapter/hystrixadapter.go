@@ -0,0 +1,119 @@
+/*
+ * Copyright 2023 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package hystrixadapter adapts github.com/afex/hystrix-go to the circuitbreak.Backend
+// interface so it can be passed to circuitbreak.NewCircuitBreakerMWWithBackend.
+package hystrixadapter
+
+import (
+	"sync"
+	"time"
+
+	"github.com/afex/hystrix-go/hystrix"
+
+	"github.com/cloudwego/kitex/pkg/circuitbreak"
+)
+
+// CommandConfigFactory builds the hystrix.CommandConfig to register for a given key,
+// the first time that key is observed.
+type CommandConfigFactory func(key string) hystrix.CommandConfig
+
+// Adapter implements circuitbreak.Backend by registering one hystrix command per key
+// on demand. No command is run through hystrix.Go: IsAllowed asks the key's circuit
+// (hystrix.GetCircuit) directly, and outcomes are reported to it as events.
+type Adapter struct {
+	newConfig CommandConfigFactory
+
+	mu         sync.Mutex
+	registered map[string]bool
+}
+
+// New creates an Adapter. newConfig is used lazily to register the hystrix command
+// for each newly observed key.
+func New(newConfig CommandConfigFactory) *Adapter {
+	return &Adapter{
+		newConfig:  newConfig,
+		registered: map[string]bool{},
+	}
+}
+
+func (a *Adapter) ensureRegistered(key string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.registered[key] {
+		return
+	}
+	hystrix.ConfigureCommand(key, a.newConfig(key))
+	a.registered[key] = true
+}
+
+// IsAllowed consults hystrix's own circuit for key, without running a command.
+func (a *Adapter) IsAllowed(key string) (bool, circuitbreak.Generation) {
+	a.ensureRegistered(key)
+	circuit, _, err := hystrix.GetCircuit(key)
+	if err != nil {
+		// Unable to resolve the circuit; fail open so a hystrix-go internal error
+		// never itself takes the service down.
+		return true, 0
+	}
+	return circuit.AllowRequest(), 0
+}
+
+// Succeed reports a successful call for key to hystrix's circuit.
+func (a *Adapter) Succeed(key string, _ circuitbreak.Generation) {
+	a.report(key, true)
+}
+
+// Fail reports a failed call for key to hystrix's circuit.
+func (a *Adapter) Fail(key string, _ circuitbreak.Generation) {
+	a.report(key, false)
+}
+
+// Timeout reports a timed-out call for key, counted the same as a failure.
+func (a *Adapter) Timeout(key string, _ circuitbreak.Generation) {
+	a.report(key, false)
+}
+
+func (a *Adapter) report(key string, succeed bool) {
+	circuit, _, err := hystrix.GetCircuit(key)
+	if err != nil {
+		return
+	}
+	_ = circuit.ReportEvent([]string{eventName(succeed)}, time.Now(), 0)
+}
+
+func eventName(succeed bool) string {
+	if succeed {
+		return "success"
+	}
+	return "failure"
+}
+
+// State returns the current hystrix state for key.
+func (a *Adapter) State(key string) circuitbreak.State {
+	circuit, _, err := hystrix.GetCircuit(key)
+	if err != nil {
+		return circuitbreak.Closed
+	}
+	if circuit.IsOpen() {
+		return circuitbreak.Open
+	}
+	return circuitbreak.Closed
+}
+
+// Subscribe is a no-op: hystrix-go has no transition notification hook exposed
+// outside of its own stream/eventsource reporting.
+func (a *Adapter) Subscribe(func(key string, from, to circuitbreak.State)) {}
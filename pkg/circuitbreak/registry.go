@@ -0,0 +1,78 @@
+/*
+ * Copyright 2023 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package circuitbreak
+
+import (
+	"hash/maphash"
+	"sync"
+	"sync/atomic"
+)
+
+// inflightRegistryShards is the number of shards the in-flight request registry is
+// split across, so cancelling one key's requests never contends with registering
+// or removing a request stored under a different key's shard.
+const inflightRegistryShards = 32
+
+// inflightRegistry tracks every in-flight request's cancel func under its breaker
+// key, so that when the key trips the middleware can cancel them all instead of
+// letting them run until their own timeout. Entries are addressed by an opaque,
+// monotonically increasing id rather than a mutex-guarded slice, so registering
+// and removing a request never blocks a concurrent cancellation sweep.
+type inflightRegistry struct {
+	seed   maphash.Seed
+	nextID uint64
+	shards [inflightRegistryShards]sync.Map // map[string]*sync.Map (id -> func())
+}
+
+func newInflightRegistry() *inflightRegistry {
+	return &inflightRegistry{seed: maphash.MakeSeed()}
+}
+
+func (r *inflightRegistry) shardFor(key string) *sync.Map {
+	var h maphash.Hash
+	h.SetSeed(r.seed)
+	_, _ = h.WriteString(key)
+	return &r.shards[h.Sum64()%inflightRegistryShards]
+}
+
+// register stores cancel under key and returns a func that removes it again; the
+// caller must always call the returned func once the request completes, typically
+// via defer, or the registry leaks an entry for the lifetime of the process.
+func (r *inflightRegistry) register(key string, cancel func()) (remove func()) {
+	shard := r.shardFor(key)
+	keyEntries, _ := shard.LoadOrStore(key, &sync.Map{})
+	entries := keyEntries.(*sync.Map)
+	id := atomic.AddUint64(&r.nextID, 1)
+	entries.Store(id, cancel)
+	return func() { entries.Delete(id) }
+}
+
+// cancelAll cancels every request currently registered under key. Entries remove
+// themselves via the func returned from register, so cancelAll does not need to
+// clear the map itself.
+func (r *inflightRegistry) cancelAll(key string) {
+	shard := r.shardFor(key)
+	v, ok := shard.Load(key)
+	if !ok {
+		return
+	}
+	entries := v.(*sync.Map)
+	entries.Range(func(_, cancel interface{}) bool {
+		cancel.(func())()
+		return true
+	})
+}
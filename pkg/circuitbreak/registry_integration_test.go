@@ -0,0 +1,65 @@
+/*
+ * Copyright 2023 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package circuitbreak
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// TestNewCircuitBreakerMWWithBackend_CancelInflightOnOpenCancelsInFlightRequests
+// drives two requests through a real NewCircuitBreakerMWWithBackend-built
+// middleware to cover the wiring between newBreakerCore, StateMachine's Hook and
+// inflightRegistry end to end: registry_test.go only ever calls register/cancelAll
+// on an inflightRegistry directly, so it could not have caught a bug in how (or
+// whether) the middleware actually connects a key tripping Open to cancelling the
+// requests already in flight for it.
+func TestNewCircuitBreakerMWWithBackend_CancelInflightOnOpenCancelsInFlightRequests(t *testing.T) {
+	sm := NewStateMachine(time.Hour, 1, 1, nil)
+	control := testControl(sm)
+	control.CancelInflightOnOpen = true
+	mw := NewCircuitBreakerMWWithBackend(control, noopBackend{})
+
+	started := make(chan struct{})
+	cancelled := make(chan struct{}, 1)
+	blocked := mw(func(ctx context.Context, request, response interface{}) error {
+		close(started)
+		<-ctx.Done()
+		cancelled <- struct{}{}
+		return ctx.Err()
+	})
+
+	done := make(chan error, 1)
+	go func() { done <- blocked(context.Background(), nil, nil) }()
+	<-started
+
+	// Trip the same key open with a second, failing call through the same
+	// middleware instance.
+	failing := mw(func(ctx context.Context, request, response interface{}) error {
+		return errors.New("boom")
+	})
+	_ = failing(context.Background(), nil, nil)
+
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("in-flight request was not cancelled when its key tripped Open")
+	}
+	<-done
+}
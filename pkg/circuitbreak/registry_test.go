@@ -0,0 +1,81 @@
+/*
+ * Copyright 2023 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package circuitbreak
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// entryCount returns how many cancel funcs are currently registered under key, so
+// tests can assert the registry does not leak an entry past the point its owning
+// request has completed.
+func (r *inflightRegistry) entryCount(key string) int {
+	shard := r.shardFor(key)
+	v, ok := shard.Load(key)
+	if !ok {
+		return 0
+	}
+	count := 0
+	v.(*sync.Map).Range(func(_, _ interface{}) bool {
+		count++
+		return true
+	})
+	return count
+}
+
+func TestInflightRegistry_CancelAllCancelsEveryRegisteredRequest(t *testing.T) {
+	r := newInflightRegistry()
+	const key = "svc.Method"
+
+	var cancelled [3]int32
+	for i := range cancelled {
+		i := i
+		r.register(key, func() { atomic.StoreInt32(&cancelled[i], 1) })
+	}
+
+	r.cancelAll(key)
+
+	for i := range cancelled {
+		if atomic.LoadInt32(&cancelled[i]) == 0 {
+			t.Fatalf("request %d was not cancelled", i)
+		}
+	}
+}
+
+func TestInflightRegistry_RegistryEmptyAfterRequestCompletes(t *testing.T) {
+	r := newInflightRegistry()
+	const key = "svc.Method"
+
+	// A request registers its cancel func on entry and must remove it again on
+	// completion, win or lose, the same way a real caller's defer unregister()
+	// would; otherwise the registry accumulates one dead entry per request for
+	// the lifetime of the process.
+	remove := r.register(key, func() {})
+	remove()
+
+	if n := r.entryCount(key); n != 0 {
+		t.Fatalf("expected registry to be empty for key %q after completion, got %d entries", key, n)
+	}
+}
+
+func TestInflightRegistry_CancelAllOnUnknownKeyIsNoop(t *testing.T) {
+	r := newInflightRegistry()
+	// cancelAll on a key nothing was ever registered under must not panic.
+	r.cancelAll("never-seen")
+}
@@ -0,0 +1,207 @@
+/*
+ * Copyright 2023 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package circuitbreak
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bytedance/gopkg/cloud/circuitbreaker"
+
+	"github.com/cloudwego/kitex/pkg/endpoint"
+	"github.com/cloudwego/kitex/pkg/kerrors"
+	"github.com/cloudwego/kitex/pkg/rpcinfo"
+)
+
+// ServerControl is the control strategy of the server-side circuit breaker.
+// It mirrors Control, except GetKey is derived from the inbound rpcinfo.RPCInfo
+// so a server can shed load per-method, per-caller, or any combination of the two
+// (e.g. "caller/svc/method"), and ErrorRate/MinimalSample can vary by key.
+type ServerControl struct {
+	// GetKey generates the breaker key from the inbound RPCInfo. Return enabled=false
+	// to bypass the breaker for this request entirely.
+	GetKey func(ri rpcinfo.RPCInfo) (key string, enabled bool)
+
+	// GetErrorType determines whether a response/err pair should count against the key.
+	GetErrorType func(ctx context.Context, request, response interface{}, err error) ErrorType
+
+	// ErrorRate returns the error rate threshold to trip on for the given key.
+	ErrorRate func(key string) float64
+
+	// MinimalSample returns the minimal sample size required before the key can trip.
+	MinimalSample func(key string) int64
+
+	// CooldownPeriod is how long a tripped key keeps rejecting requests fast before
+	// the breaker allows a request through again to re-probe the downstream. Zero
+	// means the underlying panel's own close-back behavior is used.
+	CooldownPeriod time.Duration
+
+	// DecorateError augments the error returned when a request is shed. The err
+	// argument is always a kerrors.ErrOverlimit.
+	DecorateError func(ctx context.Context, request interface{}, err error) error
+
+	// Reporter, if set, is notified of shed requests and of keys found open by the
+	// panel, so operators can export shed count / open keys / reason as metrics.
+	Reporter ServerBreakerStatReporter
+}
+
+// ServerBreakerStatReporter receives accounting events from the server circuit breaker.
+type ServerBreakerStatReporter interface {
+	// Shed is invoked every time a request is rejected by the breaker.
+	Shed(key string, reason error)
+	// StateChanged is invoked with open=true each time the panel rejects a request
+	// for key outside a cooldown period, which starts a new cooldown. It is not
+	// invoked with open=false when the key closes again.
+	StateChanged(key string, open bool)
+}
+
+// ServerCircuitBreaker wraps the middleware produced by NewServerCircuitBreakerMW
+// together with a runtime on/off switch, so operators can enable it only on the
+// write path, a specific method set, or a specific service, by wiring Activate /
+// Deactivate into per-service registration (see server.services).
+type ServerCircuitBreaker struct {
+	mw endpoint.Middleware
+	on *int32
+}
+
+// MW returns the middleware to install on the server's middleware chain.
+func (s *ServerCircuitBreaker) MW() endpoint.Middleware {
+	return s.mw
+}
+
+// Activate turns the breaker on. It is safe for concurrent use.
+func (s *ServerCircuitBreaker) Activate() {
+	atomic.StoreInt32(s.on, 1)
+}
+
+// Deactivate turns the breaker off; requests then bypass it entirely until
+// Activate is called again. It is safe for concurrent use.
+func (s *ServerCircuitBreaker) Deactivate() {
+	atomic.StoreInt32(s.on, 0)
+}
+
+// IsActive reports whether the breaker is currently enabled.
+func (s *ServerCircuitBreaker) IsActive() bool {
+	return atomic.LoadInt32(s.on) != 0
+}
+
+type serverBreakerCooldown struct {
+	mu        sync.Mutex
+	openUntil map[string]time.Time
+}
+
+func (c *serverBreakerCooldown) inCooldown(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	until, ok := c.openUntil[key]
+	return ok && time.Now().Before(until)
+}
+
+func (c *serverBreakerCooldown) trip(key string, period time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.openUntil[key] = time.Now().Add(period)
+}
+
+// NewServerCircuitBreakerMW creates a server-side circuit breaker middleware using
+// the given ServerControl and Panel. Unlike the client-side breaker, which protects
+// a caller from a misbehaving downstream, this middleware lets a server shed inbound
+// load per key once it is saturated: once a key's error rate exceeds its threshold,
+// new requests for that key are rejected fast with kerrors.ErrOverlimit for
+// the configured CooldownPeriod, instead of being allowed to pile up against an
+// already struggling resource.
+//
+// control.ErrorRate and control.MinimalSample, when set, are pushed onto panel as a
+// per-key UpdateBreakerConfig call the first time each key is seen, so a Panel
+// shared across many keys still trips each one against its own threshold instead of
+// whatever single config it was constructed with.
+func NewServerCircuitBreakerMW(control ServerControl, panel circuitbreaker.Panel) *ServerCircuitBreaker {
+	var on int32 = 1
+	cooldown := &serverBreakerCooldown{openUntil: map[string]time.Time{}}
+	var configuredKeys sync.Map // map[string]struct{}
+
+	configureKey := func(key string) {
+		if control.ErrorRate == nil && control.MinimalSample == nil {
+			return
+		}
+		if _, loaded := configuredKeys.LoadOrStore(key, struct{}{}); loaded {
+			return
+		}
+		opts := make([]circuitbreaker.ConfigOpt, 0, 2)
+		if control.ErrorRate != nil {
+			opts = append(opts, circuitbreaker.WithErrRate(control.ErrorRate(key)))
+		}
+		if control.MinimalSample != nil {
+			opts = append(opts, circuitbreaker.WithMinSample(control.MinimalSample(key)))
+		}
+		_ = panel.UpdateBreakerConfig(key, opts...)
+	}
+
+	shed := func(ctx context.Context, request interface{}, key string) error {
+		err := kerrors.ErrOverlimit
+		if control.DecorateError != nil {
+			err = control.DecorateError(ctx, request, err)
+		}
+		if control.Reporter != nil {
+			control.Reporter.Shed(key, err)
+		}
+		return err
+	}
+
+	mw := func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request, response interface{}) (err error) {
+			if atomic.LoadInt32(&on) == 0 {
+				return next(ctx, request, response)
+			}
+
+			ri := rpcinfo.GetRPCInfo(ctx)
+			key, enabled := control.GetKey(ri)
+			if !enabled {
+				return next(ctx, request, response)
+			}
+
+			configureKey(key)
+
+			if cooldown.inCooldown(key) {
+				return shed(ctx, request, key)
+			}
+
+			if !panel.IsAllowed(key) {
+				cooldown.trip(key, control.CooldownPeriod)
+				if control.Reporter != nil {
+					control.Reporter.StateChanged(key, true)
+				}
+				return shed(ctx, request, key)
+			}
+
+			err = next(ctx, request, response)
+			switch control.GetErrorType(ctx, request, response, err) {
+			case TypeTimeout:
+				panel.Timeout(key)
+			case TypeFailure:
+				panel.Fail(key)
+			case TypeSuccess:
+				panel.Succeed(key)
+			}
+			return
+		}
+	}
+
+	return &ServerCircuitBreaker{mw: mw, on: &on}
+}
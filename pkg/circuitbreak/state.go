@@ -0,0 +1,266 @@
+/*
+ * Copyright 2023 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package circuitbreak
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// StateChangeHook is called whenever a key transitions between Closed, Open and
+// HalfOpen, so callers can log or alert. reason is the error passed to Trip for a
+// transition made by Trip, and is nil for every other transition, including a
+// failed HalfOpen probe sending the key back to Open.
+type StateChangeHook func(key string, from, to State, reason error)
+
+// ResetBackoff computes how long a key stays Open before its next HalfOpen probe
+// window, as a function of how many consecutive times it has reopened (attempt
+// starts at 0 for the first trip).
+type ResetBackoff interface {
+	Next(attempt int) time.Duration
+}
+
+type constantBackoff time.Duration
+
+// ConstantBackoff always waits d before the next probe, regardless of how many
+// times the key has reopened.
+func ConstantBackoff(d time.Duration) ResetBackoff { return constantBackoff(d) }
+
+func (d constantBackoff) Next(int) time.Duration { return time.Duration(d) }
+
+type exponentialBackoff struct {
+	base, max time.Duration
+}
+
+// ExponentialBackoff doubles the wait time on every consecutive reopen, starting
+// from base and capped at max.
+func ExponentialBackoff(base, max time.Duration) ResetBackoff {
+	return exponentialBackoff{base: base, max: max}
+}
+
+func (b exponentialBackoff) Next(attempt int) time.Duration {
+	d := b.base
+	for i := 0; i < attempt; i++ {
+		if d >= b.max {
+			return b.max
+		}
+		d *= 2
+	}
+	if d > b.max {
+		d = b.max
+	}
+	return d
+}
+
+type decorrelatedJitterBackoff struct {
+	base, max time.Duration
+	mu        sync.Mutex
+	prev      time.Duration
+}
+
+// DecorrelatedJitterBackoff implements the "decorrelated jitter" strategy (AWS
+// architecture blog's improvement over plain exponential backoff): each wait is a
+// random value in [base, prev*3], capped at max, which avoids the synchronized
+// retries that independent exponential backoffs can produce.
+func DecorrelatedJitterBackoff(base, max time.Duration) ResetBackoff {
+	return &decorrelatedJitterBackoff{base: base, max: max, prev: base}
+}
+
+func (b *decorrelatedJitterBackoff) Next(attempt int) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if attempt == 0 {
+		b.prev = b.base
+		return b.base
+	}
+	top := int64(b.prev) * 3
+	if top <= int64(b.base) {
+		top = int64(b.base) + 1
+	}
+	d := time.Duration(int64(b.base) + rand.Int63n(top-int64(b.base)))
+	if d > b.max {
+		d = b.max
+	}
+	b.prev = d
+	return d
+}
+
+type keyState struct {
+	mu             sync.Mutex
+	state          State
+	attempt        int
+	nextRetry      time.Time
+	probesInFlight int
+	probeSuccesses int
+}
+
+// StateMachine is an explicit Closed / Open / HalfOpen state machine keyed by
+// breaker key. Unlike relying on a Panel to implicitly decide when a key has gone
+// quiet again, StateMachine makes the SleepWindow, half-open admission, and reset
+// backoff strategy first-class and independently configurable.
+type StateMachine struct {
+	// SleepWindow is how long a key stays Open before HalfOpen probing begins,
+	// when ResetBackoff is nil. When ResetBackoff is set, it alone decides that
+	// wait and SleepWindow is not used.
+	SleepWindow time.Duration
+	// HalfOpenMaxProbes is how many requests may be concurrently in flight while
+	// a key is HalfOpen.
+	HalfOpenMaxProbes int
+	// SuccessesToClose is how many HalfOpen probes must succeed before the key
+	// transitions back to Closed. Any single failed probe sends it back to Open.
+	SuccessesToClose int
+	// ResetBackoff computes the sleep window on every reopen, in place of
+	// SleepWindow. If nil, SleepWindow is used unchanged on every trip.
+	ResetBackoff ResetBackoff
+	// Hook, if set, is invoked on every state transition.
+	Hook StateChangeHook
+
+	mu   sync.Mutex
+	keys map[string]*keyState
+}
+
+// NewStateMachine creates a StateMachine. See the field docs for parameter meaning.
+func NewStateMachine(sleepWindow time.Duration, halfOpenMaxProbes, successesToClose int, backoff ResetBackoff) *StateMachine {
+	return &StateMachine{
+		SleepWindow:       sleepWindow,
+		HalfOpenMaxProbes: halfOpenMaxProbes,
+		SuccessesToClose:  successesToClose,
+		ResetBackoff:      backoff,
+		keys:              map[string]*keyState{},
+	}
+}
+
+func (m *StateMachine) entry(key string) *keyState {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ks, ok := m.keys[key]
+	if !ok {
+		ks = &keyState{state: Closed}
+		m.keys[key] = ks
+	}
+	return ks
+}
+
+// State returns key's current state, e.g. for an admin endpoint to dump.
+func (m *StateMachine) State(key string) State {
+	ks := m.entry(key)
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	return ks.state
+}
+
+func (m *StateMachine) sleepWindowFor(ks *keyState) time.Duration {
+	if m.ResetBackoff == nil {
+		return m.SleepWindow
+	}
+	return m.ResetBackoff.Next(ks.attempt)
+}
+
+func (m *StateMachine) notify(key string, from, to State, reason error) {
+	if m.Hook != nil {
+		m.Hook(key, from, to, reason)
+	}
+}
+
+// Trip transitions key to Open (from Closed or HalfOpen), scheduling the next
+// HalfOpen attempt after the configured SleepWindow / ResetBackoff. reason is
+// recorded and passed to Hook.
+func (m *StateMachine) Trip(key string, reason error) {
+	ks := m.entry(key)
+	ks.mu.Lock()
+	from := ks.state
+	ks.state = Open
+	ks.nextRetry = time.Now().Add(m.sleepWindowFor(ks))
+	ks.attempt++
+	ks.probesInFlight = 0
+	ks.probeSuccesses = 0
+	ks.mu.Unlock()
+	if from != Open {
+		m.notify(key, from, Open, reason)
+	}
+}
+
+// Allow reports whether a request for key may proceed. When it returns true, the
+// caller must call Report with the outcome once the request completes.
+func (m *StateMachine) Allow(key string) bool {
+	ks := m.entry(key)
+	ks.mu.Lock()
+
+	transitioned := false
+	switch ks.state {
+	case Closed:
+		ks.mu.Unlock()
+		return true
+	case Open:
+		if time.Now().Before(ks.nextRetry) {
+			ks.mu.Unlock()
+			return false
+		}
+		ks.state = HalfOpen
+		ks.probesInFlight = 0
+		ks.probeSuccesses = 0
+		transitioned = true
+		fallthrough
+	case HalfOpen:
+		if ks.probesInFlight >= m.HalfOpenMaxProbes {
+			ks.mu.Unlock()
+			return false
+		}
+		ks.probesInFlight++
+		ks.mu.Unlock()
+		// Notified after releasing ks.mu and, like Trip/Report, synchronously: Hook
+		// implementations (e.g. the CancelInflightOnOpen registry wiring) must see
+		// every transition in the order it actually happened, which an async `go
+		// m.notify(...)` here could reorder against a concurrent Trip/Report call.
+		if transitioned {
+			m.notify(key, Open, HalfOpen, nil)
+		}
+		return true
+	}
+	ks.mu.Unlock()
+	return false
+}
+
+// Report records the outcome of a request previously admitted by Allow. It has no
+// effect on a Closed key; Trip must be called to open one.
+func (m *StateMachine) Report(key string, success bool) {
+	ks := m.entry(key)
+	ks.mu.Lock()
+	if ks.state != HalfOpen {
+		ks.mu.Unlock()
+		return
+	}
+	ks.probesInFlight--
+	if !success {
+		ks.state = Open
+		ks.nextRetry = time.Now().Add(m.sleepWindowFor(ks))
+		ks.attempt++
+		ks.mu.Unlock()
+		m.notify(key, HalfOpen, Open, nil)
+		return
+	}
+	ks.probeSuccesses++
+	if ks.probeSuccesses >= m.SuccessesToClose {
+		ks.state = Closed
+		ks.attempt = 0
+		ks.mu.Unlock()
+		m.notify(key, HalfOpen, Closed, nil)
+		return
+	}
+	ks.mu.Unlock()
+}
@@ -0,0 +1,68 @@
+/*
+ * Copyright 2023 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package server
+
+import (
+	"github.com/cloudwego/kitex/pkg/circuitbreak"
+)
+
+// RegisterOptions contains configurable items for RegisterService, applied per
+// service rather than server-wide so e.g. one service can opt into the fallback
+// role or a circuit breaker without affecting any other service on the same Server.
+type RegisterOptions struct {
+	// IsFallbackService marks this service as the one a conflicting method name
+	// resolves to when registered alongside other services. See services.addService.
+	IsFallbackService bool
+
+	// ServerCircuitBreaker, if set, is stored on this service at registration,
+	// independently of whether any other registered service has one, and is returned
+	// by services.getServerCircuitBreaker and services.withServerCircuitBreaker.
+	// Storing it does not add it to the server's middleware chain by itself. See
+	// circuitbreak.NewServerCircuitBreakerMW.
+	ServerCircuitBreaker *circuitbreak.ServerCircuitBreaker
+}
+
+// RegisterOption configures RegisterOptions. Pass one or more to RegisterService.
+type RegisterOption struct {
+	F func(o *RegisterOptions)
+}
+
+// NewRegisterOptions creates a RegisterOptions by applying every opt in order.
+func NewRegisterOptions(opts []RegisterOption) *RegisterOptions {
+	o := &RegisterOptions{}
+	for _, opt := range opts {
+		opt.F(o)
+	}
+	return o
+}
+
+// WithFallbackService marks the service being registered as the fallback service:
+// a method name that conflicts across multiple registered services resolves to it
+// instead of being left ambiguous. See services.addService.
+func WithFallbackService() RegisterOption {
+	return RegisterOption{F: func(o *RegisterOptions) {
+		o.IsFallbackService = true
+	}}
+}
+
+// WithServerCircuitBreaker sets scb as RegisterOptions.ServerCircuitBreaker for the
+// service being registered. See circuitbreak.NewServerCircuitBreakerMW.
+func WithServerCircuitBreaker(scb *circuitbreak.ServerCircuitBreaker) RegisterOption {
+	return RegisterOption{F: func(o *RegisterOptions) {
+		o.ServerCircuitBreaker = scb
+	}}
+}
@@ -20,12 +20,18 @@ import (
 	"errors"
 	"fmt"
 
+	"github.com/cloudwego/kitex/pkg/circuitbreak"
+	"github.com/cloudwego/kitex/pkg/endpoint"
 	"github.com/cloudwego/kitex/pkg/serviceinfo"
 )
 
 type service struct {
 	svcInfo *serviceinfo.ServiceInfo
 	handler interface{}
+	// serverBreaker is non-nil when this service opted into the server-side
+	// circuit breaker at registration time, letting each service enable/disable
+	// load shedding independently of the others.
+	serverBreaker *circuitbreak.ServerCircuitBreaker
 }
 
 func newService(svcInfo *serviceinfo.ServiceInfo, handler interface{}) *service {
@@ -60,6 +66,7 @@ func (s *services) addService(svcInfo *serviceinfo.ServiceInfo, handler interfac
 		}
 		s.fallbackSvc = svc
 	}
+	svc.serverBreaker = registerOpts.ServerCircuitBreaker
 	s.svcMap[svcInfo.ServiceName] = svc
 	for methodName := range svcInfo.Methods {
 		s.svcSearchMap[fmt.Sprintf("%s.%s", svcInfo.ServiceName, methodName)] = svc
@@ -108,3 +115,26 @@ func (s *services) getSvcInfoSearchMap() map[string]*serviceinfo.ServiceInfo {
 	}
 	return svcInfoSearchMap
 }
+
+// getServerCircuitBreaker returns the server-side circuit breaker registered for
+// svcName, or nil if that service did not opt in.
+func (s *services) getServerCircuitBreaker(svcName string) *circuitbreak.ServerCircuitBreaker {
+	svc, ok := s.svcMap[svcName]
+	if !ok {
+		return nil
+	}
+	return svc.serverBreaker
+}
+
+// withServerCircuitBreaker appends svcName's server-side circuit breaker, if any,
+// after base. The per-request dispatch path (wherever it composes a service's
+// middleware chain from RPCInfo) must call this with that chain so a saturated
+// service can reject before the handler runs; it is a no-op for a service that
+// never set RegisterOptions.ServerCircuitBreaker.
+func (s *services) withServerCircuitBreaker(svcName string, base []endpoint.Middleware) []endpoint.Middleware {
+	scb := s.getServerCircuitBreaker(svcName)
+	if scb == nil {
+		return base
+	}
+	return append(base, scb.MW())
+}